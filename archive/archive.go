@@ -0,0 +1,276 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package archive lets cindex look inside zip and tar archives
+// (including gzip- and bzip2-compressed tarballs) and index their
+// contents under a virtual path, e.g. "sources.tar.gz!/a/b.go", the
+// same way a shell would refer to the file inside the archive.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Separator is the character that joins an archive's own path to the
+// path of an entry inside it, e.g. "foo.zip!/path/inside/file.go".
+const Separator = "!/"
+
+// Options bounds how much work Walk will do on a single archive, so
+// that indexing a crafted zip bomb can't exhaust memory or recurse
+// forever into archives nested inside archives.
+type Options struct {
+	// MaxEntrySize is the largest single entry Walk will read. Zero
+	// means a built-in default.
+	MaxEntrySize int64
+
+	// MaxArchiveSize caps how many bytes of the archive's own,
+	// on-disk content Walk will buffer in memory; zip requires the
+	// whole file to be buffered before it can be read at all, so
+	// without this cap a single oversized zip would have no size
+	// protection of its own, independent of any caller-side limit
+	// (like cindex's -max-file-size) that might not be set. Zero
+	// means a built-in default.
+	MaxArchiveSize int64
+
+	// MaxDepth is how many levels of nested archive Walk will
+	// recurse into; an archive inside a tarball counts as depth 1.
+	// Zero means a built-in default.
+	MaxDepth int
+}
+
+const (
+	defaultMaxEntrySize   = 64 << 20
+	defaultMaxArchiveSize = 512 << 20
+	defaultMaxDepth       = 4
+)
+
+func (o Options) withDefaults() Options {
+	if o.MaxEntrySize <= 0 {
+		o.MaxEntrySize = defaultMaxEntrySize
+	}
+	if o.MaxArchiveSize <= 0 {
+		o.MaxArchiveSize = defaultMaxArchiveSize
+	}
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = defaultMaxDepth
+	}
+	return o
+}
+
+// Entry is one file found inside an archive.
+type Entry struct {
+	// Name is the entry's virtual path: path + Separator + the
+	// entry's own path inside the archive.
+	Name string
+	// Size is the entry's uncompressed size, as reported by the
+	// archive format; it may be wrong for tar, whose header sizes
+	// aren't verified against the data, so callers should still
+	// bound how much they read.
+	Size int64
+	// Data is the entry's full, already-read content.
+	Data []byte
+}
+
+// IsArchive reports whether path's extension names a format Walk
+// understands.
+func IsArchive(path string) bool {
+	switch format(path) {
+	case fmtZip, fmtTar, fmtTarGz, fmtTarBz2:
+		return true
+	}
+	return false
+}
+
+type fileFormat int
+
+const (
+	fmtNone fileFormat = iota
+	fmtZip
+	fmtTar
+	fmtTarGz
+	fmtTarBz2
+)
+
+func format(path string) fileFormat {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return fmtZip
+	case strings.HasSuffix(lower, ".tar"):
+		return fmtTar
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return fmtTarGz
+	case strings.HasSuffix(lower, ".tar.bz2"), strings.HasSuffix(lower, ".tbz2"):
+		return fmtTarBz2
+	}
+	return fmtNone
+}
+
+// Walk opens the archive named path and calls fn once per regular
+// file entry it contains, depth-first, recursing into any entry that
+// is itself a recognized archive format (up to opts.MaxDepth). It
+// stops and returns fn's error the first time fn returns one.
+func Walk(path string, opts Options, fn func(Entry) error) error {
+	opts = opts.withDefaults()
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return walkReader(path, f, format(path), opts, 0, fn)
+}
+
+func walkReader(path string, r io.Reader, kind fileFormat, opts Options, depth int, fn func(Entry) error) error {
+	switch kind {
+	case fmtZip:
+		return walkZip(path, r, opts, depth, fn)
+	case fmtTar:
+		return walkTar(path, r, opts, depth, fn)
+	case fmtTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		return walkTar(path, gz, opts, depth, fn)
+	case fmtTarBz2:
+		return walkTar(path, bzip2.NewReader(r), opts, depth, fn)
+	default:
+		return fmt.Errorf("%s: not a recognized archive format", path)
+	}
+}
+
+func walkZip(path string, r io.Reader, opts Options, depth int, fn func(Entry) error) error {
+	// zip.Reader needs ReaderAt+size, so buffer it, bounded by
+	// MaxArchiveSize regardless of whatever size limit (if any) the
+	// caller's own walk applied before handing us the archive.
+	data, err := ioutil.ReadAll(io.LimitReader(r, opts.MaxArchiveSize+1))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if int64(len(data)) > opts.MaxArchiveSize {
+		return fmt.Errorf("%s: archive exceeds %d byte limit", path, opts.MaxArchiveSize)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := visitEntry(path, f.Name, f.UncompressedSize64, func() (io.ReadCloser, error) { return f.Open() }, opts, depth, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(path string, r io.Reader, opts Options, depth int, fn func(Entry) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, size, body := hdr.Name, hdr.Size, tr
+		if err := visitEntry(path, name, uint64(size), func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(body), nil
+		}, opts, depth, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// visitEntry reads one archive entry (bounded by opts.MaxEntrySize),
+// invokes fn on it under its virtual name, and, if the entry is
+// itself a recognized archive and depth allows, recurses into it.
+func visitEntry(archivePath, entryName string, size uint64, open func() (io.ReadCloser, error), opts Options, depth int, fn func(Entry) error) error {
+	virtual := archivePath + Separator + entryName
+
+	rc, err := open()
+	if err != nil {
+		return fmt.Errorf("%s: %w", virtual, err)
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rc, opts.MaxEntrySize+1))
+	if err != nil {
+		return fmt.Errorf("%s: %w", virtual, err)
+	}
+	truncated := int64(len(data)) > opts.MaxEntrySize
+	if truncated {
+		data = data[:opts.MaxEntrySize]
+	}
+
+	if err := fn(Entry{Name: virtual, Size: int64(size), Data: data}); err != nil {
+		return err
+	}
+
+	if !truncated && depth < opts.MaxDepth {
+		if nested := format(entryName); nested != fmtNone {
+			if err := walkReader(virtual, bytes.NewReader(data), nested, opts, depth+1, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Split breaks a virtual path produced by Walk back into the
+// on-disk archive path and the entry's path inside it.
+func Split(virtual string) (archivePath, entryPath string, ok bool) {
+	i := strings.Index(virtual, Separator)
+	if i < 0 {
+		return "", "", false
+	}
+	return virtual[:i], virtual[i+len(Separator):], true
+}
+
+// Open resolves a virtual path produced by Walk back to its content,
+// for on-demand display of a match: it opens the named archive
+// (recursing through any nested archives named in the virtual path)
+// and returns the single matching entry's data.
+//
+// In this tree, cindex calls Open to re-read an archive entry when it
+// rewrites the index. csearch's match-printing path isn't checked in
+// here, so nothing in this tree uses Open to display a match.
+func Open(virtual string) (io.ReadCloser, error) {
+	archivePath, entryPath, ok := Split(virtual)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an archive-relative path", virtual)
+	}
+	var found *Entry
+	err := Walk(archivePath, Options{}, func(e Entry) error {
+		if e.Name == virtual || strings.TrimPrefix(e.Name, archivePath+Separator) == entryPath {
+			found = &e
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: entry not found", virtual)
+	}
+	return ioutil.NopCloser(bytes.NewReader(found.Data)), nil
+}
+
+var errStop = fmt.Errorf("archive: stop walking")
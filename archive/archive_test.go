@@ -0,0 +1,130 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeZip(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(dir, "t.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWalkZip(t *testing.T) {
+	dir := t.TempDir()
+	path := writeZip(t, dir, map[string]string{
+		"a.go": "package a\n",
+		"b.go": "package b\n",
+	})
+
+	var got []string
+	err := Walk(path, Options{}, func(e Entry) error {
+		got = append(got, e.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{path + Separator + "a.go", path + Separator + "b.go"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	seen := map[string]bool{}
+	for _, g := range got {
+		seen[g] = true
+	}
+	for _, w := range want {
+		if !seen[w] {
+			t.Errorf("missing entry %q in %v", w, got)
+		}
+	}
+}
+
+func TestWalkZipMaxArchiveSize(t *testing.T) {
+	dir := t.TempDir()
+	path := writeZip(t, dir, map[string]string{
+		"big.go": "package big\n",
+	})
+
+	err := Walk(path, Options{MaxArchiveSize: 4}, func(e Entry) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error when the archive exceeds MaxArchiveSize, got nil")
+	}
+}
+
+func TestWalkZipMaxEntrySize(t *testing.T) {
+	dir := t.TempDir()
+	path := writeZip(t, dir, map[string]string{
+		"big.go": "package big; var x = 1\n",
+	})
+
+	var data []byte
+	err := Walk(path, Options{MaxEntrySize: 4}, func(e Entry) error {
+		data = e.Data
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4 {
+		t.Errorf("got %d bytes, want entry truncated to 4", len(data))
+	}
+}
+
+func TestSplit(t *testing.T) {
+	archivePath, entryPath, ok := Split("a.zip" + Separator + "b/c.go")
+	if !ok || archivePath != "a.zip" || entryPath != "b/c.go" {
+		t.Errorf("Split: got (%q, %q, %v)", archivePath, entryPath, ok)
+	}
+	if _, _, ok := Split("no-separator"); ok {
+		t.Error("Split: expected ok=false for a path with no separator")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := writeZip(t, dir, map[string]string{
+		"a.go": "package a\n",
+	})
+
+	rc, err := Open(path + Separator + "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(rc); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "package a\n" {
+		t.Errorf("got %q, want %q", buf.String(), "package a\n")
+	}
+}
@@ -5,23 +5,32 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"runtime/pprof"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/codesearch/archive"
 	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/index/ignore"
+	"github.com/google/codesearch/index/symbols"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/htmlindex"
 )
 
-var usageMessage = `usage: cindex [-list] [-reset] [-encodings utf8,iso8859-2] [path...]
+var usageMessage = `usage: cindex [-list] [-reset] [-j N] [-since duration|time] [-watch]
+	[-ignore-file path] [-include glob] [-exclude glob]
+	[-max-file-size bytes] [-binary=skip|index|detect]
+	[-encodings utf8,iso8859-2] [-encoding-detect=auto|strict|off] [path...]
 
 Cindex prepares the trigram index for use by csearch.  The index is the
 file named by $CSEARCHINDEX, or else $HOME/.csearchindex.
@@ -44,6 +53,65 @@ If cindex is invoked with no paths, it reindexes the paths that have
 already been added, in case the files have changed.  Thus, 'cindex' by
 itself is a useful command to run in a nightly cron job.
 
+The -j flag sets how many worker goroutines extract trigrams
+concurrently; it defaults to GOMAXPROCS. Indexing is always done this
+way, so -j 1 is the way to force single-threaded indexing.
+
+The -encodings flag lists the candidate encodings cindex will consider
+for each file that has no byte-order mark. A file that is already
+valid UTF-8 gets no special treatment, and among candidates that score
+equally the one listed first wins. The -encoding-detect flag controls
+how cindex chooses among them:
+
+	auto	sniff a byte-order mark, then pick the best-scoring
+		candidate decoder (the default)
+	strict	only trust a byte-order mark; never guess
+	off	disable detection and index every file as raw bytes
+
+Reindexing is incremental: cindex keeps a sidecar file of each indexed
+file's size and modification time, and skips reading any file whose
+stat hasn't changed since the last run. The -since flag replaces that
+check with a time bound: only files modified after a point in time are
+read, whether or not their stat changed; it accepts either a Go duration
+("24h") meaning "that long ago", or an RFC 3339 timestamp.
+
+The -watch flag keeps cindex running after the initial index build,
+watching the indexed paths for changes with fsnotify and folding
+batches of changes into the index incrementally instead of exiting.
+When that initial build was a full one (with -reset, or with no
+existing index), every later batch rebuilds the index in full as well.
+
+cindex always skips .git, .hg, .svn and .bzr directories, regardless
+of .gitignore content. Beyond that, it skips whatever a .gitignore or
+.csearchignore names, the same way git would, applying each
+directory's own rules to it and its descendants and letting deeper or
+later "!"-negated patterns override shallower ones. The -ignore-file
+flag (repeatable) adds another pattern file, applied everywhere rather
+than just below one directory.
+-include and -exclude (repeatable) add plain glob filters, matched
+against each path relative to the argument being indexed, independent
+of any .gitignore.
+
+-max-file-size drops files larger than the given number of bytes.
+-binary controls what happens to files that look binary (containing a
+NUL byte in their first few KB): "skip" leaves them out of the index
+entirely, "index" indexes them like any other file, and "detect" (the
+default) indexes them but skips the encoding-detection pass, since
+running a text decoder over binary data is both wasted work and a
+likely source of encoding-detection false positives.
+
+The -archives flag makes cindex look inside .zip, .tar, .tar.gz/.tgz
+and .tar.bz2/.tbz2 files it encounters and index each entry under a
+virtual path, e.g. "sources.tar.gz!/a/b.go"; an archive nested inside
+another archive is indexed the same way, up to a fixed recursion
+depth, and an oversized entry is truncated rather than exhausting
+memory. This costs more to index, so it's off by default.
+
+The -symbols flag additionally builds an identifier index: for every
+Go file, the declared funcs, methods (with receiver), types, vars and
+consts; for other files, whatever -symbol-rules' ctags-style rules
+file matches. Query it with the csymbol command.
+
 The -list flag causes cindex to list the paths it has indexed and exit.
 
 By default cindex adds the named paths to the index but preserves 
@@ -64,8 +132,42 @@ var (
 	verboseFlag   = flag.Bool("verbose", false, "print extra information")
 	cpuProfile    = flag.String("cpuprofile", "", "write cpu profile to this file")
 	encodingsFlag = flag.String("encodings", "", "what encodings to use - comma separated list")
+	encDetectFlag = flag.String("encoding-detect", "auto", "how to pick a file's encoding: auto, strict, or off")
+	jFlag         = flag.Int("j", runtime.GOMAXPROCS(0), "number of worker goroutines used to extract trigrams")
+	sinceFlag     = flag.String("since", "", "only reindex files modified after this duration ago or RFC 3339 timestamp")
+	watchFlag     = flag.Bool("watch", false, "stay resident and reindex files as they change")
+
+	ignoreFileFlag  stringList
+	includeFlag     stringList
+	excludeFlag     stringList
+	maxFileSizeFlag = flag.Int64("max-file-size", 0, "skip files larger than this many bytes (0 means no limit)")
+	binaryFlag      = flag.String("binary", "detect", "how to handle binary files: skip, index, or detect")
+	archivesFlag    = flag.Bool("archives", false, "look inside zip and tar archives and index their entries")
+	symbolsFlag     = flag.Bool("symbols", false, "build an identifier index alongside the trigram index; query it with csymbol")
+	symbolRulesFlag = flag.String("symbol-rules", "", "ctags-style rules file for extracting symbols from non-Go files")
 )
 
+func init() {
+	flag.Var(&ignoreFileFlag, "ignore-file", "additional ignore-pattern file, applied everywhere (repeatable)")
+	flag.Var(&includeFlag, "include", "only index paths matching this glob (repeatable)")
+	flag.Var(&excludeFlag, "exclude", "never index paths matching this glob (repeatable)")
+}
+
+// stringList is a flag.Value that accumulates repeated -flag=value
+// occurrences into a slice.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// watchDebounce is how long cindex waits after the last fsnotify event
+// in a burst before it reindexes, so that e.g. a save-and-recompile
+// doesn't trigger a reindex per intermediate write.
+const watchDebounce = 500 * time.Millisecond
+
 func main() {
 	flag.Usage = usage
 	flag.Parse()
@@ -91,6 +193,8 @@ func main() {
 
 	if *resetFlag && len(args) == 0 {
 		os.Remove(index.File())
+		os.Remove(index.MetaFile(index.File()))
+		os.Remove(symbols.File(index.File()))
 		return
 	}
 	if len(args) == 0 {
@@ -120,15 +224,26 @@ func main() {
 		args = args[1:]
 	}
 
-	master := index.File()
-	if _, err := os.Stat(master); err != nil {
-		// Does not exist.
-		*resetFlag = true
+	switch *encDetectFlag {
+	case "auto", "strict", "off":
+	default:
+		log.Fatalf("invalid -encoding-detect %q: must be auto, strict, or off", *encDetectFlag)
 	}
-	file := master
-	if !*resetFlag {
-		file += "~"
+	switch *binaryFlag {
+	case "skip", "index", "detect":
+	default:
+		log.Fatalf("invalid -binary %q: must be skip, index, or detect", *binaryFlag)
 	}
+
+	var since time.Time
+	if *sinceFlag != "" {
+		t, err := parseSince(*sinceFlag)
+		if err != nil {
+			log.Fatalf("-since: %s", err)
+		}
+		since = t
+	}
+
 	var encodings []encoding.Encoding
 	for _, enc := range strings.Split(*encodingsFlag, ",") {
 		if enc = strings.TrimSpace(enc); enc == "" {
@@ -142,64 +257,410 @@ func main() {
 		encodings = append(encodings, e)
 	}
 
-	ix := index.Create(file)
-	ix.Verbose = *verboseFlag
+	if err := buildIndex(args, encodings, since); err != nil {
+		log.Fatal(err)
+	}
+
+	if *watchFlag {
+		watch(args, encodings)
+	}
+}
+
+// parseSince parses the -since flag, which is either a Go duration
+// ("24h", meaning "that long ago") or an RFC 3339 timestamp.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// buildIndex walks args and brings $CSEARCHINDEX up to date. Unless
+// -reset is in effect, it consults the sidecar metadata next to the
+// existing index to skip files whose size and mtime haven't changed,
+// and arranges for changed or deleted files' stale postings to be
+// dropped from the old index before the new content is merged in. If
+// since is non-zero, it overrides the metadata check: only files
+// modified after since are considered at all.
+func buildIndex(args []string, encodings []encoding.Encoding, since time.Time) error {
+	master := index.File()
+	if _, err := os.Stat(master); err != nil {
+		// Does not exist.
+		*resetFlag = true
+	}
+	file := master
+	if !*resetFlag {
+		file += "~"
+	}
+
+	var meta *index.MetaStore
+	if !*resetFlag {
+		m, err := index.OpenMetaStore(master)
+		if err != nil {
+			return fmt.Errorf("open metadata: %w", err)
+		}
+		meta = m
+	}
+
+	var symRules []symbols.Rule
+	if *symbolRulesFlag != "" {
+		data, err := os.ReadFile(*symbolRulesFlag)
+		if err != nil {
+			return fmt.Errorf("-symbol-rules: %w", err)
+		}
+		if symRules, err = symbols.ParseRules(data); err != nil {
+			return fmt.Errorf("-symbol-rules: %w", err)
+		}
+	}
+	var symTable *symbols.Table
+	if *symbolsFlag {
+		symTable = symbols.NewTable()
+	}
+
+	seen := make(map[string]bool)
+	var replaced, deleted []string
+
+	ix := index.CreateParallel(file, index.ParallelOptions{
+		Workers: *jFlag,
+		Verbose: *verboseFlag,
+		OnError: func(path string, err error) { log.Printf("%s: %s", path, err) },
+	})
 	ix.AddPaths(args)
 	for _, arg := range args {
 		log.Printf("index %s", arg)
-		filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
-			if _, elem := filepath.Split(path); elem != "" {
-				// Skip various temporary or "hidden" files or directories.
-				if elem[0] == '.' || elem[0] == '#' || elem[0] == '~' || elem[len(elem)-1] == '~' {
-					if info.IsDir() {
-						return filepath.SkipDir
-					}
-					return nil
-				}
+		m := ignore.NewMatcher(arg, ".gitignore", ".csearchignore")
+		for _, f := range ignoreFileFlag {
+			if err := m.AddFile(f); err != nil {
+				log.Printf("%s: %s", f, err)
 			}
+		}
+		m.AddIncludeGlobs(includeFlag)
+		m.AddExcludeGlobs(excludeFlag)
+
+		filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				log.Printf("%s: %s", path, err)
 				return nil
 			}
-			if info != nil && info.Mode()&os.ModeType == 0 {
-				r, err := openEncoded(path, encodings)
+			if info.IsDir() {
+				if isVCSDir(path) {
+					return filepath.SkipDir
+				}
+				m.Enter(path)
+				if m.Ignored(path, true) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode()&os.ModeType != 0 {
+				return nil
+			}
+			if m.Ignored(path, false) {
+				return nil
+			}
+			if *maxFileSizeFlag > 0 && info.Size() > *maxFileSizeFlag {
+				return nil
+			}
+			seen[path] = true
+
+			skip := false
+			switch {
+			case !since.IsZero():
+				skip = !info.ModTime().After(since)
+			case meta != nil:
+				skip = meta.Unchanged(path, info)
+			}
+			if skip {
+				return nil
+			}
+			if meta != nil && meta.Known(path) {
+				replaced = append(replaced, path)
+			}
+
+			if *archivesFlag && archive.IsArchive(path) {
+				err := archive.Walk(path, archive.Options{}, func(e archive.Entry) error {
+					// Like the plain-file branch below, defer the
+					// decode (and any symbol extraction, which needs
+					// the decoded bytes) to the read closure, so it
+					// runs on the worker goroutine that dequeues this
+					// job instead of serializing behind the archive
+					// walk.
+					ix.Add(e.Name, func() ([]byte, error) {
+						data := decodeData(e.Data, encodings, *encDetectFlag)
+						if symTable != nil {
+							if extractor := symbolExtractorFor(e.Name, symRules); extractor != nil {
+								if err := symTable.AddFile(extractor, e.Name, data); err != nil {
+									log.Printf("%s: %s", e.Name, err)
+								}
+							}
+						}
+						return data, nil
+					})
+					return nil
+				})
 				if err != nil {
-					return fmt.Errorf("%q: %w", path, err)
+					log.Printf("%s: %s", path, err)
 				}
-				ix.Add(path, r)
-				r.Close()
+				if meta != nil {
+					meta.Record(path, info)
+				}
+				return nil
 			}
+
+			// The actual read — opening the file, sniffing for binary
+			// content, and decoding it to the chosen encoding — runs
+			// on whichever worker goroutine dequeues this job, not
+			// here, so it doesn't serialize behind the walk.
+			ix.Add(path, func() ([]byte, error) {
+				decodeMode := *encDetectFlag
+				if *binaryFlag != "index" {
+					bin, err := looksBinary(path)
+					if err != nil {
+						return nil, fmt.Errorf("%q: %w", path, err)
+					}
+					if bin && *binaryFlag == "skip" {
+						return nil, nil
+					}
+					if bin {
+						decodeMode = "off"
+					}
+				}
+
+				r, err := openEncoded(path, encodings, decodeMode)
+				if err != nil {
+					return nil, fmt.Errorf("%q: %w", path, err)
+				}
+				data, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					return nil, fmt.Errorf("%q: %w", path, err)
+				}
+
+				if symTable != nil {
+					if extractor := symbolExtractorFor(path, symRules); extractor != nil {
+						if err := symTable.AddFile(extractor, path, data); err != nil {
+							log.Printf("%s: %s", path, err)
+						}
+					}
+				}
+				if meta != nil {
+					meta.Record(path, info)
+				}
+				return data, nil
+			})
 			return nil
 		})
 	}
+
+	if meta != nil {
+		for _, p := range meta.Paths() {
+			if !seen[p] {
+				deleted = append(deleted, p)
+			}
+		}
+	}
+
 	log.Printf("flush index")
 	ix.Flush()
 
+	stale := append(append([]string{}, replaced...), deleted...)
 	if !*resetFlag {
+		if len(stale) > 0 {
+			log.Printf("rewrite %s, dropping %d stale path(s)", master, len(stale))
+			if err := index.RemoveFiles(master, stale, reopenIndexed(encodings)); err != nil {
+				log.Printf("rewrite %s: %s", master, err)
+			}
+			if _, err := os.Stat(symbols.File(master)); err == nil {
+				if err := symbols.RemoveFiles(master, stale); err != nil {
+					log.Printf("prune symbols: %s", err)
+				}
+			}
+		}
+		for _, p := range deleted {
+			meta.Forget(p)
+		}
 		log.Printf("merge %s %s", master, file)
 		index.Merge(file+"~", master, file)
 		os.Remove(file)
 		os.Rename(file+"~", master)
 	}
+
+	if symTable != nil {
+		if err := symbols.Save(file, symTable); err != nil {
+			log.Printf("save symbols: %s", err)
+		} else if !*resetFlag {
+			if err := symbols.Merge(master, master, file); err != nil {
+				log.Printf("merge symbols: %s", err)
+			}
+			os.Remove(symbols.File(file))
+		}
+	}
+
+	if meta != nil {
+		if err := meta.Save(); err != nil {
+			return fmt.Errorf("save metadata: %w", err)
+		}
+	}
 	log.Printf("done")
-	return
+	return nil
 }
 
-func openEncoded(path string, encodings []encoding.Encoding) (io.ReadCloser, error) {
-	fh, err := os.Open(path)
+// watch keeps cindex resident, reindexing args whenever fsnotify
+// reports changes underneath them. Events are debounced by
+// watchDebounce so a burst of writes triggers one reindex, not one
+// per event.
+func watch(args []string, encodings []encoding.Encoding) {
+	w, err := fsnotify.NewWatcher()
 	if err != nil {
-		return nil, err
+		log.Fatalf("watch: %s", err)
 	}
-	var found encoding.Encoding
-	for _, enc := range encodings {
-		r := enc.NewDecoder().Reader(fh)
-		if _, err = io.Copy(ioutil.Discard, r); err == nil {
-			found = enc
+	defer w.Close()
+
+	for _, arg := range args {
+		m := ignore.NewMatcher(arg, ".gitignore", ".csearchignore")
+		for _, f := range ignoreFileFlag {
+			if err := m.AddFile(f); err != nil {
+				log.Printf("%s: %s", f, err)
+			}
 		}
-		if _, err = fh.Seek(0, 0); err != nil {
-			return nil, err
+		m.AddIncludeGlobs(includeFlag)
+		m.AddExcludeGlobs(excludeFlag)
+
+		filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info == nil || !info.IsDir() {
+				return nil
+			}
+			if isVCSDir(path) {
+				return filepath.SkipDir
+			}
+			m.Enter(path)
+			if m.Ignored(path, true) {
+				return filepath.SkipDir
+			}
+			return w.Add(path)
+		})
+	}
+	log.Printf("watching %d path(s) for changes", len(args))
+
+	var timer *time.Timer
+	pending := make(chan struct{}, 1)
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if timer == nil {
+				timer = time.AfterFunc(watchDebounce, func() { pending <- struct{}{} })
+			} else {
+				timer.Reset(watchDebounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("watch: %s", err)
+		case <-pending:
+			timer = nil
+			if err := buildIndex(args, encodings, time.Time{}); err != nil {
+				log.Printf("reindex: %s", err)
+			}
 		}
 	}
+}
+
+// vcsDirs are version-control metadata directories cindex always
+// skips, independent of any .gitignore: the ignore rules come from
+// files like .gitignore and -ignore-file, and a .gitignore typically
+// doesn't bother excluding .git, since git itself never needs to.
+// Without this, -archives aside, cindex would by default walk and
+// index an entire .git object database.
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true, ".bzr": true}
+
+func isVCSDir(path string) bool {
+	return vcsDirs[filepath.Base(path)]
+}
+
+// looksBinary applies the same NUL-byte heuristic git and most other
+// tools use: a file containing a NUL in its first few KB is almost
+// certainly not text, regardless of what encoding it might otherwise
+// decode as.
+func looksBinary(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, 8000)
+	n, err := f.Read(buf)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.IndexByte(buf[:n], 0) >= 0, nil
+}
+
+// symbolExtractorFor picks the symbols.Extractor for path: Go source
+// gets the real go/parser-based extractor, anything else falls back
+// to rules (from -symbol-rules) if any were given, and otherwise
+// isn't extracted at all.
+func symbolExtractorFor(path string, rules []symbols.Rule) symbols.Extractor {
+	if strings.HasSuffix(path, ".go") {
+		return symbols.GoExtractor{}
+	}
+	if len(rules) > 0 {
+		return symbols.RegexExtractor{Rules: rules}
+	}
+	return nil
+}
+
+// decodeData applies the same encoding choice openEncoded would to
+// an in-memory blob, for archive entries that are already fully read
+// into memory rather than backed by an *os.File.
+func decodeData(data []byte, encodings []encoding.Encoding, mode string) []byte {
+	if mode == "off" {
+		return data
+	}
+	candidates := encodings
+	if mode == "strict" {
+		candidates = nil
+	}
+	enc, err := index.DetectEncoding(bytes.NewReader(data), candidates)
+	if err != nil || enc == nil {
+		return data
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return data
+	}
+	return decoded
+}
+
+// openEncoded opens path and, unless detection is disabled, wraps it
+// in a decoder for whichever of encodings its contents appear to be
+// written in. mode is the -encoding-detect setting: "auto" sniffs a
+// BOM and falls back to scoring each candidate decoder, "strict" only
+// trusts a BOM, and "off" always returns the raw bytes.
+func openEncoded(path string, encodings []encoding.Encoding, mode string) (io.ReadCloser, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "off" {
+		return fh, nil
+	}
+
+	candidates := encodings
+	if mode == "strict" {
+		candidates = nil
+	}
+	found, err := index.DetectEncoding(fh, candidates)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
 	if found == nil {
 		return fh, nil
 	}
@@ -208,3 +669,45 @@ func openEncoded(path string, encodings []encoding.Encoding) (io.ReadCloser, err
 		io.Closer
 	}{found.NewDecoder().Reader(fh), fh}, nil
 }
+
+// reopenIndexed returns the opener index.RemoveFiles uses to re-read a
+// path still present in the index during a rewrite. It has to resolve
+// a path the exact same way the initial walk in buildIndex did:
+// archive.Split reports whether path is an archive's virtual path, in
+// which case archive.Open resolves it back to the entry's content,
+// which is then decoded with decodeData as the walk's archive branch does;
+// otherwise it's a plain file, sniffed and decoded the same way
+// buildIndex's own read closure does. Without this, an unrelated
+// change elsewhere would force index.RemoveFiles to fall back to a
+// bare file open for every other indexed path, reverting non-UTF-8
+// files to raw bytes and silently dropping every archive entry.
+func reopenIndexed(encodings []encoding.Encoding) func(path string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		if _, _, ok := archive.Split(path); ok {
+			rc, err := archive.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", path, err)
+			}
+			return io.NopCloser(bytes.NewReader(decodeData(data, encodings, *encDetectFlag))), nil
+		}
+		decodeMode := *encDetectFlag
+		if *binaryFlag != "index" {
+			bin, err := looksBinary(path)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", path, err)
+			}
+			if bin {
+				if *binaryFlag == "skip" {
+					return nil, fmt.Errorf("%q: binary, skipped", path)
+				}
+				decodeMode = "off"
+			}
+		}
+		return openEncoded(path, encodings, decodeMode)
+	}
+}
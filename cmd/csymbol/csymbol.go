@@ -0,0 +1,61 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Csymbol looks up a declaration by name in the identifier index that
+// cindex -symbols builds alongside $CSEARCHINDEX, and prints every
+// place it's declared.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/google/codesearch/index"
+)
+
+var usageMessage = `usage: csymbol name
+
+Csymbol looks up name in the identifier index built by cindex -symbols
+(with -symbol-rules for non-Go files) and prints every declaration
+found, one per line, as
+
+	file:line: kind — snippet
+
+or, for a method,
+
+	file:line: method (recv) name — snippet
+`
+
+func usage() {
+	fmt.Fprint(os.Stderr, usageMessage)
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 1 {
+		usage()
+	}
+	name := args[0]
+
+	spots, err := index.LookupSymbol(index.File(), name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(spots) == 0 {
+		fmt.Fprintf(os.Stderr, "csymbol: no declarations of %q\n", name)
+		os.Exit(1)
+	}
+	for _, sp := range spots {
+		if sp.Recv != "" {
+			fmt.Printf("%s:%d: method (%s) %s — %s\n", sp.File, sp.Line, sp.Recv, name, sp.Snippet)
+			continue
+		}
+		fmt.Printf("%s:%d: %s — %s\n", sp.File, sp.Line, sp.Kind, sp.Snippet)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+// sniffSize is how much of the file DetectEncoding reads before
+// scoring candidate decoders.
+const sniffSize = 32 * 1024
+
+// minConfidence is the lowest score DetectEncoding will accept before
+// giving up and returning nil (raw bytes).
+const minConfidence = 0.8
+
+// bomEncoding reports the encoding implied by a byte-order mark at the
+// start of buf, and the length of that mark, or (nil, 0) if buf does
+// not begin with a recognized BOM.
+func bomEncoding(buf []byte) (encoding.Encoding, int) {
+	switch {
+	case bytes.HasPrefix(buf, []byte{0xEF, 0xBB, 0xBF}):
+		return unicode.UTF8BOM, 3
+	case bytes.HasPrefix(buf, []byte{0x00, 0x00, 0xFE, 0xFF}):
+		return utf32.UTF32(utf32.BigEndian, utf32.ExpectBOM), 4
+	case bytes.HasPrefix(buf, []byte{0xFF, 0xFE, 0x00, 0x00}):
+		return utf32.UTF32(utf32.LittleEndian, utf32.ExpectBOM), 4
+	case bytes.HasPrefix(buf, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), 2
+	case bytes.HasPrefix(buf, []byte{0xFF, 0xFE}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), 2
+	}
+	return nil, 0
+}
+
+// scoreDecoded scores decoded text in the range [0,1]: higher is a
+// better match for "this really is text in the candidate encoding".
+// It penalizes U+FFFD replacement runes (decode failures) and
+// non-printable bytes, and disqualifies output in which NULs make up
+// more than 1 in 50 runes (a strong sign the decoder is misinterpreting
+// the file's encoding). Only the share of NULs counts; their positions
+// are not considered.
+func scoreDecoded(decoded []byte) float64 {
+	if len(decoded) == 0 {
+		return 0
+	}
+	var total, bad, nul int
+	for i, r := 0, 0; i < len(decoded); i += r {
+		var ru rune
+		ru, r = utf8.DecodeRune(decoded[i:])
+		total++
+		switch {
+		case ru == utf8.RuneError:
+			bad++
+		case ru == 0:
+			nul++
+		case ru == '\n' || ru == '\r' || ru == '\t':
+			// control whitespace is fine
+		case ru < 0x20 || ru == 0x7F:
+			bad++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	// NULs making up more than 1 in 50 runes (as from a misdetected
+	// UTF-16 stream) are disqualifying.
+	if nul > total/50 {
+		return 0
+	}
+	return 1 - float64(bad)/float64(total)
+}
+
+// DetectEncoding determines which of candidates best describes the
+// contents read from r. It first looks for an unambiguous byte-order
+// mark; failing that, it decodes a leading chunk of the file with
+// each candidate and scores the result, returning the encoding with
+// the highest score. If no candidate scores at least minConfidence,
+// DetectEncoding returns (nil, nil): the caller should treat the file
+// as raw bytes.
+func DetectEncoding(r io.ReaderAt, candidates []encoding.Encoding) (encoding.Encoding, error) {
+	head := make([]byte, sniffSize)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	if enc, _ := bomEncoding(head); enc != nil {
+		return enc, nil
+	}
+
+	var best encoding.Encoding
+	var bestScore float64
+	for _, enc := range candidates {
+		decoded, decErr := enc.NewDecoder().Bytes(head)
+		if decErr != nil {
+			continue
+		}
+		if score := scoreDecoded(decoded); score > bestScore {
+			bestScore, best = score, enc
+		}
+	}
+	if best == nil || bestScore < minConfidence {
+		return nil, nil
+	}
+	return best, nil
+}
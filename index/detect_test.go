@@ -0,0 +1,93 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestBOMEncoding(t *testing.T) {
+	cases := []struct {
+		name string
+		buf  []byte
+		want int // expected BOM length, 0 for no match
+	}{
+		{"utf8", []byte{0xEF, 0xBB, 0xBF, 'h', 'i'}, 3},
+		{"utf16be", []byte{0xFE, 0xFF, 0, 'h'}, 2},
+		{"utf16le", []byte{0xFF, 0xFE, 'h', 0}, 2},
+		{"utf32be", []byte{0x00, 0x00, 0xFE, 0xFF}, 4},
+		{"utf32le", []byte{0xFF, 0xFE, 0x00, 0x00}, 4},
+		{"none", []byte("hello"), 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			enc, n := bomEncoding(c.buf)
+			if n != c.want {
+				t.Errorf("bomEncoding(%v) length = %d, want %d", c.buf, n, c.want)
+			}
+			if (enc != nil) != (c.want != 0) {
+				t.Errorf("bomEncoding(%v) encoding = %v, want non-nil = %v", c.buf, enc, c.want != 0)
+			}
+		})
+	}
+}
+
+func TestScoreDecodedCleanText(t *testing.T) {
+	score := scoreDecoded([]byte("package main\n\nfunc main() {}\n"))
+	if score < minConfidence {
+		t.Errorf("score of clean source text = %v, want >= %v", score, minConfidence)
+	}
+}
+
+func TestScoreDecodedGarbage(t *testing.T) {
+	garbage := bytes.Repeat([]byte{0x01, 0x02, 0x1F}, 20)
+	score := scoreDecoded(garbage)
+	if score >= minConfidence {
+		t.Errorf("score of control-byte garbage = %v, want < %v", score, minConfidence)
+	}
+}
+
+func TestScoreDecodedEmpty(t *testing.T) {
+	if score := scoreDecoded(nil); score != 0 {
+		t.Errorf("score of empty input = %v, want 0", score)
+	}
+}
+
+func TestDetectEncodingBOM(t *testing.T) {
+	data := append([]byte{0xEF, 0xBB, 0xBF}, []byte("hello")...)
+	enc, err := DetectEncoding(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc == nil {
+		t.Fatal("DetectEncoding returned nil encoding for a BOM-prefixed file")
+	}
+}
+
+func TestDetectEncodingNoCandidates(t *testing.T) {
+	// No BOM and no candidates to try: DetectEncoding must fall back
+	// to (nil, nil) rather than erroring.
+	enc, err := DetectEncoding(bytes.NewReader([]byte("plain ascii text\n")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc != nil {
+		t.Errorf("DetectEncoding with no candidates = %v, want nil", enc)
+	}
+}
+
+func TestDetectEncodingCandidate(t *testing.T) {
+	enc, err := DetectEncoding(bytes.NewReader([]byte("plain ascii text\n")), []encoding.Encoding{charmap.ISO8859_1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc != charmap.ISO8859_1 {
+		t.Errorf("DetectEncoding = %v, want ISO8859_1 to score high enough on clean ASCII", enc)
+	}
+}
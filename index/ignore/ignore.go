@@ -0,0 +1,230 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ignore implements gitignore-style path exclusion, the kind
+// cindex's walker uses to skip files in .gitignore and
+// .csearchignore, plus the simpler glob rules of -include/-exclude.
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pattern is one parsed line of a gitignore-style file.
+type pattern struct {
+	negate   bool // leading "!"
+	dirOnly  bool // trailing "/"
+	anchored bool // leading "/", or any "/" before the final segment
+	glob     string
+}
+
+func parsePattern(line string) (pattern, bool) {
+	line = strings.TrimRight(line, " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+	var p pattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasPrefix(line, "\\") {
+		// Escaped leading "!" or "#".
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = line[:len(line)-1]
+	}
+	if line == "" {
+		return pattern{}, false
+	}
+	if strings.HasPrefix(line, "/") {
+		p.anchored = true
+		line = line[1:]
+	} else if strings.Contains(line, "/") {
+		p.anchored = true
+	}
+	p.glob = line
+	return p, true
+}
+
+func parsePatterns(data string) []pattern {
+	var pats []pattern
+	for _, line := range strings.Split(data, "\n") {
+		if p, ok := parsePattern(strings.TrimRight(line, "\r")); ok {
+			pats = append(pats, p)
+		}
+	}
+	return pats
+}
+
+// matchGlob matches a slash-separated gitignore glob against a
+// slash-separated relative path, honoring "**" as "zero or more path
+// segments" and single path segments via filepath.Match.
+func matchGlob(glob, name string) bool {
+	return matchParts(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pat, name []string) bool {
+	if len(pat) == 0 {
+		return len(name) == 0
+	}
+	if pat[0] == "**" {
+		if len(pat) == 1 {
+			return true
+		}
+		for i := 0; i <= len(name); i++ {
+			if matchParts(pat[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := filepath.Match(pat[0], name[0])
+	return ok && matchParts(pat[1:], name[1:])
+}
+
+// matches reports whether rel (slash-separated, relative to the
+// directory that owns p) is matched by p.
+func (p pattern) matches(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.anchored {
+		return matchGlob(p.glob, rel)
+	}
+	// An unanchored pattern matches the path's final segment at any
+	// depth, same as a leading "**/" would.
+	return matchGlob(p.glob, filepath.Base(rel)) || matchGlob("**/"+p.glob, rel)
+}
+
+type level struct {
+	dir      string
+	patterns []pattern
+}
+
+// Matcher composes stacked gitignore-style rule sets the way a real
+// walker encounters them: root-level rules (from -ignore-file and
+// -exclude/-include) apply everywhere, and each directory's own
+// .gitignore/.csearchignore rules apply to it and its descendants,
+// with later (deeper, or later-in-file) patterns overriding earlier
+// ones, exactly as git itself resolves the stack.
+type Matcher struct {
+	root    string
+	names   []string // ignore file names to look for in each directory, e.g. ".gitignore"
+	global  []pattern
+	levels  []level
+	include []string
+	exclude []string
+}
+
+// NewMatcher returns a Matcher rooted at root. names lists the
+// filenames (".gitignore", ".csearchignore") that Enter should look
+// for in each directory it's given.
+func NewMatcher(root string, names ...string) *Matcher {
+	return &Matcher{root: root, names: names}
+}
+
+// AddFile reads a standalone ignore file (as passed via -ignore-file)
+// and adds its patterns at the root, so they apply everywhere.
+func (m *Matcher) AddFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.global = append(m.global, parsePatterns(string(data))...)
+	return nil
+}
+
+// AddIncludeGlobs and AddExcludeGlobs record -include/-exclude
+// command-line globs, matched against the path relative to root
+// regardless of any .gitignore.
+func (m *Matcher) AddIncludeGlobs(globs []string) { m.include = append(m.include, globs...) }
+func (m *Matcher) AddExcludeGlobs(globs []string) { m.exclude = append(m.exclude, globs...) }
+
+// Enter loads dir's own ignore files (if any) and pushes them onto
+// the stack, first popping any levels left over from a directory
+// cindex's walker has already finished visiting. Since filepath.Walk
+// has no "leaving a directory" callback, callers call Enter with
+// every directory they visit, in walk order, and the stack corrects
+// itself.
+func (m *Matcher) Enter(dir string) {
+	for len(m.levels) > 0 {
+		top := m.levels[len(m.levels)-1].dir
+		if top == dir || strings.HasPrefix(dir, top+string(filepath.Separator)) {
+			break
+		}
+		m.levels = m.levels[:len(m.levels)-1]
+	}
+
+	var pats []pattern
+	for _, name := range m.names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		pats = append(pats, parsePatterns(string(data))...)
+	}
+	m.levels = append(m.levels, level{dir: dir, patterns: pats})
+}
+
+// Ignored reports whether path (absolute, or relative to the current
+// working directory the same way the rest of the level dirs are)
+// should be skipped. The -include globs only gate files: a directory
+// is never pruned for failing to match one, since the glob describes
+// the files a caller wants, not the directories it takes to reach
+// them, and pruning by directory name would also cut off any matching
+// file nested underneath.
+func (m *Matcher) Ignored(path string, isDir bool) bool {
+	if rel, err := filepath.Rel(m.root, path); err == nil && len(m.include) > 0 && !isDir {
+		rel = filepath.ToSlash(rel)
+		included := false
+		for _, g := range m.include {
+			if ok, _ := filepath.Match(g, rel); ok {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return true
+		}
+	}
+	if rel, err := filepath.Rel(m.root, path); err == nil {
+		rel = filepath.ToSlash(rel)
+		for _, g := range m.exclude {
+			if ok, _ := filepath.Match(g, rel); ok {
+				return true
+			}
+		}
+	}
+
+	ignored := false
+	if rel, err := filepath.Rel(m.root, path); err == nil {
+		rel = filepath.ToSlash(rel)
+		for _, p := range m.global {
+			if p.matches(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	for _, lv := range m.levels {
+		rel, err := filepath.Rel(lv.dir, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range lv.patterns {
+			if p.matches(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		glob, name string
+		want       bool
+	}{
+		{"*.go", "a.go", true},
+		{"*.go", "a.txt", false},
+		{"*.go", "sub/a.go", false},
+		{"**/*.go", "sub/a.go", true},
+		{"**/*.go", "a.go", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/y/c", false},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.glob, c.name); got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.glob, c.name, got, c.want)
+		}
+	}
+}
+
+func TestPatternMatches(t *testing.T) {
+	p, ok := parsePattern("/build")
+	if !ok {
+		t.Fatal("parsePattern(\"/build\") failed")
+	}
+	if !p.matches("build", false) {
+		t.Error("anchored pattern /build should match top-level \"build\"")
+	}
+	if p.matches("sub/build", false) {
+		t.Error("anchored pattern /build should not match \"sub/build\"")
+	}
+
+	p, ok = parsePattern("*.log")
+	if !ok {
+		t.Fatal("parsePattern(\"*.log\") failed")
+	}
+	if !p.matches("sub/a.log", false) {
+		t.Error("unanchored pattern *.log should match at any depth")
+	}
+
+	p, ok = parsePattern("out/")
+	if !ok {
+		t.Fatal("parsePattern(\"out/\") failed")
+	}
+	if p.matches("out", false) {
+		t.Error("dir-only pattern out/ should not match a non-directory")
+	}
+	if !p.matches("out", true) {
+		t.Error("dir-only pattern out/ should match a directory named out")
+	}
+}
+
+func TestMatcherNegation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\n!keep.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMatcher(dir, ".gitignore")
+	m.Enter(dir)
+
+	if !m.Ignored(filepath.Join(dir, "a.log"), false) {
+		t.Error("a.log should be ignored by *.log")
+	}
+	if m.Ignored(filepath.Join(dir, "keep.log"), false) {
+		t.Error("keep.log should be un-ignored by the later !keep.log negation")
+	}
+}
+
+func TestMatcherEnterPopsStaleLevels(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, ".gitignore"), []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMatcher(root, ".gitignore")
+	m.Enter(root)
+	m.Enter(sub)
+	if len(m.levels) != 2 {
+		t.Fatalf("after entering root then sub, have %d levels, want 2", len(m.levels))
+	}
+
+	// Walking back out to a sibling of sub should pop sub's level.
+	sibling := filepath.Join(root, "sibling")
+	m.Enter(sibling)
+	if len(m.levels) != 2 {
+		t.Fatalf("after entering a sibling of sub, have %d levels, want 2 (root + sibling)", len(m.levels))
+	}
+	if m.Ignored(filepath.Join(sibling, "a.tmp"), false) {
+		t.Error("sub's *.tmp rule leaked into a sibling directory")
+	}
+}
+
+func TestMatcherIncludeExclude(t *testing.T) {
+	root := t.TempDir()
+	m := NewMatcher(root)
+	m.AddIncludeGlobs([]string{"*.go"})
+	m.AddExcludeGlobs([]string{"gen_*.go"})
+
+	if m.Ignored(filepath.Join(root, "a.go"), false) {
+		t.Error("a.go matches the include glob and should not be ignored")
+	}
+	if !m.Ignored(filepath.Join(root, "a.txt"), false) {
+		t.Error("a.txt doesn't match any include glob and should be ignored")
+	}
+	if !m.Ignored(filepath.Join(root, "gen_a.go"), false) {
+		t.Error("gen_a.go matches the exclude glob and should be ignored despite matching include")
+	}
+}
+
+func TestMatcherIncludeSparesDirectories(t *testing.T) {
+	root := t.TempDir()
+	m := NewMatcher(root)
+	m.AddIncludeGlobs([]string{"*.go"})
+
+	if m.Ignored(filepath.Join(root, "sub"), true) {
+		t.Error("a directory named \"sub\" doesn't match *.go, but should not be ignored: -include globs only gate files, not directories")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileStat is the per-file metadata cindex's incremental mode uses to
+// decide whether a file needs to be re-read: its size and
+// modification time, as reported by os.Stat. Two stats compare equal
+// (see FileStat.Unchanged) when both match, which is enough to skip
+// re-reading a file without hashing its content.
+type FileStat struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Unchanged reports whether info describes the same file content as
+// fs, based on size and modification time.
+func (fs FileStat) Unchanged(info os.FileInfo) bool {
+	return fs.Size == info.Size() && fs.ModTime.Equal(info.ModTime())
+}
+
+// statOf returns the FileStat recorded by os.Stat's result.
+func statOf(info os.FileInfo) FileStat {
+	return FileStat{Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// MetaStore is a sidecar file, stored next to an index, recording the
+// FileStat cindex observed for every indexed path the last time it
+// ran. It lets an incremental reindex skip any file whose size and
+// mtime haven't changed, instead of reading and re-trigramming it.
+type MetaStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]FileStat
+	dirty   bool
+}
+
+// MetaFile returns the sidecar metadata path for the index file named
+// indexFile.
+func MetaFile(indexFile string) string {
+	return indexFile + ".meta"
+}
+
+// OpenMetaStore reads the sidecar metadata file for indexFile, if one
+// exists. A missing sidecar is not an error: it just means every file
+// in the coming walk will be treated as new.
+func OpenMetaStore(indexFile string) (*MetaStore, error) {
+	m := &MetaStore{path: MetaFile(indexFile), entries: make(map[string]FileStat)}
+	f, err := os.Open(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&m.entries); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Known reports whether path was recorded on a previous run.
+func (m *MetaStore) Known(path string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.entries[path]
+	return ok
+}
+
+// Unchanged reports whether path's previously recorded stat matches
+// info. A path that was never recorded is always "changed".
+func (m *MetaStore) Unchanged(path string, info os.FileInfo) bool {
+	m.mu.Lock()
+	fs, ok := m.entries[path]
+	m.mu.Unlock()
+	return ok && fs.Unchanged(info)
+}
+
+// Record updates path's stat to match info.
+func (m *MetaStore) Record(path string, info os.FileInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[path] = statOf(info)
+	m.dirty = true
+}
+
+// Forget removes path, e.g. because the walk no longer found it on
+// disk.
+func (m *MetaStore) Forget(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, path)
+	m.dirty = true
+}
+
+// Paths returns every path currently recorded.
+func (m *MetaStore) Paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.entries))
+	for p := range m.entries {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// Save writes the metadata back to its sidecar file, if anything
+// changed since it was opened or last saved.
+func (m *MetaStore) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	tmp := m.path + "~"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(m.entries); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// RemoveFiles drops every path in paths from the index stored at
+// indexFile in a single rebuild pass, leaving every other indexed
+// path untouched. It works by rebuilding the index from every other
+// currently-indexed path still present on disk, which costs as much
+// as reindexing those paths, so callers with several files to drop
+// (e.g. one incremental run's whole batch of changed and deleted
+// paths) must call this once with all of them, not once per path: the
+// rebuild itself re-reads and re-trigrams the entire remaining
+// corpus, so calling it in a loop would cost that corpus's full size
+// once per removed path.
+//
+// open re-reads a still-present path's current content. It must run
+// the path through the same pipeline the original indexing walk did
+// (binary sniffing, encoding detection, archive resolution for
+// Separator-joined virtual paths), or the rewritten index will
+// silently diverge from what a fresh reindex would have produced: a
+// bare os.Open, for instance, can't resolve an archive entry's
+// virtual path at all, and would drop it instead of carrying its
+// postings forward. An error from open is treated the same as the
+// path being gone: it's dropped from the rewritten index rather than
+// failing the whole rewrite.
+func RemoveFiles(indexFile string, paths []string, open func(path string) (io.ReadCloser, error)) error {
+	return rewriteExcept(indexFile, paths, open)
+}
+
+// RemoveFile is RemoveFiles for a single path.
+func RemoveFile(indexFile, path string, open func(path string) (io.ReadCloser, error)) error {
+	return RemoveFiles(indexFile, []string{path}, open)
+}
+
+// ReplaceFile removes path's old postings from indexFile in
+// preparation for the caller adding the file's new content to a
+// separate IndexWriter that will then be merged in. Without this
+// step, Merge would carry the stale entry for path forward alongside
+// the fresh one. Like RemoveFiles, prefer batching several replaced
+// paths into one call over calling this in a loop.
+func ReplaceFile(indexFile, path string, open func(path string) (io.ReadCloser, error)) error {
+	return RemoveFiles(indexFile, []string{path}, open)
+}
+
+// rewriteExcept rebuilds the index at indexFile without the named
+// paths, in one pass over the old index's contents, re-reading every
+// other indexed path through open.
+func rewriteExcept(indexFile string, paths []string, open func(path string) (io.ReadCloser, error)) error {
+	excl := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		excl[p] = true
+	}
+
+	ix := Open(indexFile)
+	tmp := indexFile + ".rewrite"
+	iw := Create(tmp)
+	iw.AddPaths(ix.Paths())
+	for i := 0; i < ix.NumName(); i++ {
+		name := ix.Name(uint32(i))
+		if excl[name] {
+			continue
+		}
+		r, err := open(name)
+		if err != nil {
+			// The file is gone too; drop it rather than fail the
+			// whole rewrite.
+			continue
+		}
+		iw.Add(name, r)
+		r.Close()
+	}
+	iw.Flush()
+	return os.Rename(tmp, indexFile)
+}
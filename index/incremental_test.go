@@ -0,0 +1,107 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetaStoreUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idxFile := filepath.Join(dir, "index")
+	m, err := OpenMetaStore(idxFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Known(path) {
+		t.Fatal("Known reported true before any Record")
+	}
+	if m.Unchanged(path, info) {
+		t.Fatal("Unchanged reported true for a path never recorded")
+	}
+
+	m.Record(path, info)
+	if !m.Known(path) {
+		t.Fatal("Known reported false right after Record")
+	}
+	if !m.Unchanged(path, info) {
+		t.Fatal("Unchanged reported false for the same stat just recorded")
+	}
+
+	changed := FileStat{Size: info.Size() + 1, ModTime: info.ModTime()}
+	if changed.Unchanged(info) {
+		t.Fatal("FileStat.Unchanged reported true despite a size mismatch")
+	}
+
+	m.Forget(path)
+	if m.Known(path) {
+		t.Fatal("Known reported true after Forget")
+	}
+}
+
+func TestMetaStoreSaveReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	idxFile := filepath.Join(dir, "index")
+
+	m, err := OpenMetaStore(idxFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Record(path, fakeFileInfo{size: 5, modTime: time.Unix(1000, 0)})
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := OpenMetaStore(idxFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m2.Known(path) {
+		t.Fatal("reopened MetaStore lost a saved path")
+	}
+	if !m2.Unchanged(path, fakeFileInfo{size: 5, modTime: time.Unix(1000, 0)}) {
+		t.Fatal("reopened MetaStore's stat didn't round-trip")
+	}
+}
+
+func TestMetaStoreSaveNotDirty(t *testing.T) {
+	dir := t.TempDir()
+	idxFile := filepath.Join(dir, "index")
+	m, err := OpenMetaStore(idxFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(MetaFile(idxFile)); err == nil {
+		t.Fatal("Save wrote a sidecar file despite nothing being recorded")
+	}
+}
+
+type fakeFileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (f fakeFileInfo) Name() string       { return "" }
+func (f fakeFileInfo) Size() int64        { return f.size }
+func (f fakeFileInfo) Mode() os.FileMode  { return 0 }
+func (f fakeFileInfo) ModTime() time.Time { return f.modTime }
+func (f fakeFileInfo) IsDir() bool        { return false }
+func (f fakeFileInfo) Sys() interface{}   { return nil }
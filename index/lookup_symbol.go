@@ -0,0 +1,24 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import "github.com/google/codesearch/index/symbols"
+
+// LookupSymbol returns every declaration of name recorded in the
+// symbol sidecar built alongside the trigram index named indexFile
+// (see index/symbols and cindex's -symbols flag). It returns an empty
+// result, not an error, if indexFile has no symbol sidecar.
+//
+// This lives next to indexFile rather than as an Index method because
+// the symbol table is a sidecar file today, not yet a section of the
+// .csearchindex format itself; LookupSymbol is the seam a future
+// format version can move behind without disturbing callers.
+func LookupSymbol(indexFile, name string) ([]symbols.Spot, error) {
+	t, err := symbols.Open(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	return t.Lookup(name), nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// defaultShardSize is how many bytes of file content a worker
+// accumulates before it sorts, indexes, and flushes its batch to a
+// temporary shard.
+const defaultShardSize = 256 << 20
+
+// ParallelOptions configures a ParallelWriter.
+type ParallelOptions struct {
+	// Workers is the number of goroutines that read, decode and
+	// extract trigrams from files concurrently. Zero means
+	// runtime.GOMAXPROCS(0).
+	Workers int
+
+	// ShardSize is how many bytes of file content a worker buffers
+	// before indexing and flushing a shard. Zero means a sensible
+	// default.
+	ShardSize int64
+
+	// Verbose is copied to every shard's IndexWriter. It must be set
+	// here, not after CreateParallel returns: workers start reading
+	// jobs immediately, and a shard's IndexWriter is created only
+	// when that worker's first batch is flushed, so setting it later
+	// on the returned ParallelWriter would race with that read.
+	Verbose bool
+
+	// OnError, if non-nil, is called from a worker goroutine whenever
+	// a job's read function returns an error. Add itself can't report
+	// the error, since by the time read runs Add has already
+	// returned; OnError is the only place it surfaces. It may be
+	// called concurrently from multiple workers.
+	OnError func(path string, err error)
+}
+
+// parallelJob is one file queued for a worker to read and index.
+type parallelJob struct {
+	path string
+	read func() ([]byte, error)
+}
+
+// shardEntry is one file's content, already read, waiting to be
+// batched into a worker's next shard.
+type shardEntry struct {
+	path string
+	data []byte
+}
+
+// ParallelWriter builds a trigram index the same way IndexWriter does,
+// but spreads the read/decode/trigram-extract work across a pool of
+// worker goroutines, each writing its own partial index to a temp
+// shard, and combines the shards with a tree of Merge calls once
+// Flush is called. Its AddPaths/Flush methods match IndexWriter's; Add
+// does not, since it defers the read that produces a file's content
+// to the worker that indexes it (see Add).
+type ParallelWriter struct {
+	file string
+	opts ParallelOptions
+
+	jobs   chan parallelJob
+	shards chan string
+	wg     sync.WaitGroup
+	paths  []string
+}
+
+// CreateParallel returns a new ParallelWriter that will write the
+// final merged index to file once Flush is called.
+func CreateParallel(file string, opts ParallelOptions) *ParallelWriter {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+	if opts.ShardSize <= 0 {
+		opts.ShardSize = defaultShardSize
+	}
+
+	pw := &ParallelWriter{
+		file:   file,
+		opts:   opts,
+		jobs:   make(chan parallelJob, opts.Workers*4),
+		shards: make(chan string, opts.Workers*8),
+	}
+
+	pw.wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go func(worker int) {
+			defer pw.wg.Done()
+			pw.runWorker(worker)
+		}(i)
+	}
+	go func() {
+		pw.wg.Wait()
+		close(pw.shards)
+	}()
+	return pw
+}
+
+// AddPaths records the top-level paths being indexed, the same way
+// IndexWriter.AddPaths does; they are carried through to the final
+// merged index untouched.
+func (pw *ParallelWriter) AddPaths(paths []string) {
+	pw.paths = append(pw.paths, paths...)
+}
+
+// Add queues path for indexing on a worker goroutine: read is called
+// there, not by the caller, so whatever I/O and decoding it takes to
+// produce path's content (opening the file, sniffing for binary
+// content, converting encodings) runs off the caller's goroutine, in
+// parallel with every other queued file's read, instead of blocking
+// the caller before the (cheap, CPU-only) trigram extraction step
+// ever sees the file. A nil, nil result tells the worker to skip path
+// silently, e.g. because it turned out to be binary and the caller
+// wants those excluded; an error is reported to opts.OnError instead
+// of to Add, which has already returned by the time read runs.
+func (pw *ParallelWriter) Add(path string, read func() ([]byte, error)) {
+	pw.jobs <- parallelJob{path: path, read: read}
+}
+
+// runWorker pulls jobs until the channel is closed, running each
+// job's read on this goroutine, batching the results by ShardSize,
+// and flushing each batch to its own temp shard in path order.
+func (pw *ParallelWriter) runWorker(worker int) {
+	var batch []shardEntry
+	var size int64
+	shard := 0
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		sort.Slice(batch, func(i, j int) bool { return batch[i].path < batch[j].path })
+		name := fmt.Sprintf("%s.shard-%d-%d", pw.file, worker, shard)
+		shard++
+
+		iw := Create(name)
+		iw.Verbose = pw.opts.Verbose
+		for _, e := range batch {
+			iw.Add(e.path, bytes.NewReader(e.data))
+		}
+		iw.Flush()
+
+		pw.shards <- name
+		batch = batch[:0]
+		size = 0
+	}
+
+	for job := range pw.jobs {
+		data, err := job.read()
+		if err != nil {
+			if pw.opts.OnError != nil {
+				pw.opts.OnError(job.path, err)
+			}
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		batch = append(batch, shardEntry{job.path, data})
+		size += int64(len(data))
+		if size >= pw.opts.ShardSize {
+			flush()
+		}
+	}
+	flush()
+}
+
+// Flush waits for all workers to finish, combines their shards in a
+// pairwise merge tree (log(N) rounds, so no single Merge call ever
+// touches more than two inputs), and writes the result to the file
+// name passed to CreateParallel.
+func (pw *ParallelWriter) Flush() {
+	close(pw.jobs)
+
+	var shards []string
+	for name := range pw.shards {
+		shards = append(shards, name)
+	}
+	sort.Strings(shards)
+
+	if len(shards) == 0 {
+		iw := Create(pw.file)
+		iw.AddPaths(pw.paths)
+		iw.Flush()
+		return
+	}
+
+	// A shard carrying nothing but the top-level path list, so it
+	// rides along through the merge tree the same way the others do.
+	pathsShard := pw.file + ".shard-paths"
+	iw := Create(pathsShard)
+	iw.AddPaths(pw.paths)
+	iw.Flush()
+	shards = append(shards, pathsShard)
+	sort.Strings(shards)
+
+	for len(shards) > 1 {
+		var next []string
+		for i := 0; i < len(shards); i += 2 {
+			if i+1 == len(shards) {
+				next = append(next, shards[i])
+				continue
+			}
+			out := shards[i] + ".merged"
+			Merge(out, shards[i], shards[i+1])
+			os.Remove(shards[i])
+			os.Remove(shards[i+1])
+			next = append(next, out)
+		}
+		shards = next
+	}
+	os.Rename(shards[0], pw.file)
+}
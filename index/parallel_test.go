@@ -0,0 +1,88 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeParallel(t testing.TB, file string, paths []string, workers int) {
+	t.Helper()
+	pw := CreateParallel(file, ParallelOptions{Workers: workers})
+	pw.AddPaths(paths)
+	for _, p := range paths {
+		p := p
+		pw.Add(p, func() ([]byte, error) { return os.ReadFile(p) })
+	}
+	pw.Flush()
+}
+
+// TestParallelWriterDeterministic indexes the same corpus once with a
+// single worker and once with several, and checks the two resulting
+// index files are byte-for-byte identical. Sharding the work across
+// workers and merging the shards back together must not depend on
+// which worker happens to finish a file first: Add's read closures
+// run concurrently, but runWorker sorts each batch by path before
+// flushing it, and Flush's merge tree combines shards in a fixed,
+// sorted order, so the result shouldn't vary with Workers at all.
+func TestParallelWriterDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go": "package a\n\nfunc A() {}\n",
+		"b.go": "package b\n\nfunc B() {}\n",
+		"c.go": "package c\n\nfunc C() {}\n",
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	index1 := filepath.Join(dir, "index1")
+	index4 := filepath.Join(dir, "index4")
+	writeParallel(t, index1, paths, 1)
+	writeParallel(t, index4, paths, 4)
+
+	got1, err := os.ReadFile(index1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got4, err := os.ReadFile(index4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got1, got4) {
+		t.Error("indexing the same corpus with Workers: 1 and Workers: 4 produced different index files")
+	}
+}
+
+// BenchmarkParallelWriter indexes a few hundred small files end to
+// end, to track the cost of the worker pool and merge tree themselves
+// rather than any one file's read or trigram extraction.
+func BenchmarkParallelWriter(b *testing.B) {
+	dir := b.TempDir()
+	var paths []string
+	for i := 0; i < 200; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("f%d.go", i))
+		content := fmt.Sprintf("package p\n\nfunc F%d() {}\n", i)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			b.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("bench-%d", i))
+		writeParallel(b, file, paths, 4)
+	}
+}
@@ -0,0 +1,422 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package symbols builds an identifier index alongside cindex's
+// trigram index: a map from declaration name to every place it's
+// declared, inspired by godoc's word-to-spot index. Extraction is
+// pluggable — a Go extractor built on go/parser and go/ast, and a
+// generic ctags-style regex extractor for everything else — so the
+// index can grow more languages without changing its storage format.
+package symbols
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Kind classifies a declaration.
+type Kind int
+
+const (
+	Func Kind = iota
+	Method
+	Type
+	Var
+	Const
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Func:
+		return "func"
+	case Method:
+		return "method"
+	case Type:
+		return "type"
+	case Var:
+		return "var"
+	case Const:
+		return "const"
+	default:
+		return "unknown"
+	}
+}
+
+// Spot is one place a name is declared.
+type Spot struct {
+	Name    string
+	File    string
+	Line    int
+	Kind    Kind
+	Recv    string // receiver type, for Method; empty otherwise
+	Snippet string // the declaration's source line, for display
+}
+
+// Extractor finds declarations in a single file's content.
+type Extractor interface {
+	Extract(path string, data []byte) ([]Spot, error)
+}
+
+// GoExtractor extracts func, type, var, const and method (with
+// receiver) declarations from Go source using go/parser.
+type GoExtractor struct{}
+
+// Extract implements Extractor.
+func (GoExtractor) Extract(path string, data []byte) ([]Spot, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	snippet := func(line int) string {
+		if line-1 < 0 || line-1 >= len(lines) {
+			return ""
+		}
+		return strings.TrimSpace(string(lines[line-1]))
+	}
+
+	var spots []Spot
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			line := fset.Position(d.Pos()).Line
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				spots = append(spots, Spot{Name: d.Name.Name, File: path, Line: line, Kind: Func, Snippet: snippet(line)})
+				continue
+			}
+			spots = append(spots, Spot{
+				Name:    d.Name.Name,
+				File:    path,
+				Line:    line,
+				Kind:    Method,
+				Recv:    recvTypeName(d.Recv.List[0].Type),
+				Snippet: snippet(line),
+			})
+		case *ast.GenDecl:
+			kind := Var
+			switch d.Tok {
+			case token.TYPE:
+				kind = Type
+			case token.CONST:
+				kind = Const
+			case token.VAR:
+				kind = Var
+			default:
+				continue
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					line := fset.Position(s.Pos()).Line
+					spots = append(spots, Spot{Name: s.Name.Name, File: path, Line: line, Kind: Type, Snippet: snippet(line)})
+				case *ast.ValueSpec:
+					line := fset.Position(s.Pos()).Line
+					for _, name := range s.Names {
+						spots = append(spots, Spot{Name: name.Name, File: path, Line: line, Kind: kind, Snippet: snippet(line)})
+					}
+				}
+			}
+		}
+	}
+	return spots, nil
+}
+
+func recvTypeName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// Rule is one ctags-style regex extraction rule for RegexExtractor:
+// Pattern's first capturing group is the declared name.
+type Rule struct {
+	Pattern *regexp.Regexp
+	Kind    Kind
+}
+
+// RegexExtractor is a generic, configurable fallback for languages
+// without a dedicated Extractor: it scans a file line by line and
+// reports a Spot wherever a rule's pattern matches.
+type RegexExtractor struct {
+	Rules []Rule
+}
+
+// Extract implements Extractor.
+func (e RegexExtractor) Extract(path string, data []byte) ([]Spot, error) {
+	var spots []Spot
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		for _, r := range e.Rules {
+			m := r.Pattern.FindStringSubmatch(text)
+			if m == nil || len(m) < 2 {
+				continue
+			}
+			spots = append(spots, Spot{
+				Name:    m[1],
+				File:    path,
+				Line:    line,
+				Kind:    r.Kind,
+				Snippet: strings.TrimSpace(text),
+			})
+		}
+	}
+	return spots, sc.Err()
+}
+
+// ParseRules reads a rules file with one rule per line, in the form
+//
+//	kind<TAB>regex
+//
+// where kind is one of func, method, type, var, const, and regex's
+// first capturing group is the declared name. Blank lines and lines
+// starting with "#" are ignored.
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"kind<TAB>regex\"", i+1)
+		}
+		kind, err := parseKind(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		re, err := regexp.Compile(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rules = append(rules, Rule{Pattern: re, Kind: kind})
+	}
+	return rules, nil
+}
+
+func parseKind(s string) (Kind, error) {
+	switch s {
+	case "func":
+		return Func, nil
+	case "method":
+		return Method, nil
+	case "type":
+		return Type, nil
+	case "var":
+		return Var, nil
+	case "const":
+		return Const, nil
+	}
+	return 0, fmt.Errorf("unknown kind %q", s)
+}
+
+// entry is a Spot with its snippet replaced by an index into the
+// owning Table's snippet table, so that many hits sharing the same
+// source line share storage too.
+type entry struct {
+	File      string
+	Line      int
+	Kind      Kind
+	Recv      string
+	SnippetID int
+}
+
+// Table is the in-memory (and on-disk, via encodeTo/decodeTable) form
+// of the symbol index: name -> spots, plus deduplicated snippet
+// storage.
+type Table struct {
+	mu         sync.Mutex
+	byName     map[string][]entry
+	snippets   []string
+	snippetIdx map[string]int
+}
+
+// NewTable returns an empty Table.
+func NewTable() *Table {
+	return &Table{byName: make(map[string][]entry), snippetIdx: make(map[string]int)}
+}
+
+// Add records one declaration of name.
+func (t *Table) Add(name string, sp Spot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, ok := t.snippetIdx[sp.Snippet]
+	if !ok {
+		id = len(t.snippets)
+		t.snippets = append(t.snippets, sp.Snippet)
+		t.snippetIdx[sp.Snippet] = id
+	}
+	t.byName[name] = append(t.byName[name], entry{
+		File: sp.File, Line: sp.Line, Kind: sp.Kind, Recv: sp.Recv, SnippetID: id,
+	})
+}
+
+// AddFile runs extractor over data and adds every Spot it finds.
+func (t *Table) AddFile(extractor Extractor, path string, data []byte) error {
+	spots, err := extractor.Extract(path, data)
+	if err != nil {
+		return err
+	}
+	for _, sp := range spots {
+		if sp.Name != "" {
+			t.Add(sp.Name, sp)
+		}
+	}
+	return nil
+}
+
+// Lookup returns every recorded declaration of name.
+func (t *Table) Lookup(name string) []Spot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := t.byName[name]
+	spots := make([]Spot, len(entries))
+	for i, e := range entries {
+		spots[i] = Spot{File: e.File, Line: e.Line, Kind: e.Kind, Recv: e.Recv, Snippet: t.snippets[e.SnippetID]}
+	}
+	return spots
+}
+
+// onDisk is the gob-serializable form of a Table.
+type onDisk struct {
+	Version  int
+	ByName   map[string][]entry
+	Snippets []string
+}
+
+const tableVersion = 1
+
+// encodeTo gob-encodes the table.
+func (t *Table) encodeTo(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return gob.NewEncoder(w).Encode(onDisk{Version: tableVersion, ByName: t.byName, Snippets: t.snippets})
+}
+
+// decodeTable decodes a table written by (*Table).encodeTo.
+func decodeTable(r io.Reader) (*Table, error) {
+	var d onDisk
+	if err := gob.NewDecoder(r).Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.Version != tableVersion {
+		return nil, fmt.Errorf("symbols: unsupported table version %d", d.Version)
+	}
+	t := NewTable()
+	t.byName = d.ByName
+	t.snippets = d.Snippets
+	for i, s := range d.Snippets {
+		t.snippetIdx[s] = i
+	}
+	return t, nil
+}
+
+// File returns the sidecar path symbols.Open/Save use for the trigram
+// index stored at indexFile.
+func File(indexFile string) string {
+	return indexFile + ".symbols"
+}
+
+// Open reads the symbol sidecar for indexFile. A missing sidecar is
+// not an error: it returns an empty Table, the same as an index with
+// no symbols extracted.
+func Open(indexFile string) (*Table, error) {
+	f, err := os.Open(File(indexFile))
+	if os.IsNotExist(err) {
+		return NewTable(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeTable(f)
+}
+
+// Save writes t to indexFile's symbol sidecar.
+func Save(indexFile string, t *Table) error {
+	tmp := File(indexFile) + "~"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := t.encodeTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, File(indexFile))
+}
+
+// RemoveFiles drops every entry whose File is in paths from the
+// symbol sidecar at indexFile, the symbols counterpart to
+// index.RemoveFiles: without it, a changed or deleted file's stale
+// declarations would survive every Merge indefinitely, since Merge
+// only ever adds entries from its two inputs. Callers with several
+// paths to drop should batch them into one call, same as
+// index.RemoveFiles.
+func RemoveFiles(indexFile string, paths []string) error {
+	t, err := Open(indexFile)
+	if err != nil {
+		return err
+	}
+	excl := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		excl[p] = true
+	}
+	filtered := NewTable()
+	for name, entries := range t.byName {
+		for _, e := range entries {
+			if excl[e.File] {
+				continue
+			}
+			filtered.Add(name, Spot{File: e.File, Line: e.Line, Kind: e.Kind, Recv: e.Recv, Snippet: t.snippets[e.SnippetID]})
+		}
+	}
+	return Save(indexFile, filtered)
+}
+
+// Merge combines two indexes' symbol sidecars (src1 and src2, named
+// by their trigram index files) into dstIndexFile's sidecar, the
+// symbol-table counterpart to index.Merge. Snippets are deduplicated
+// across both inputs, not just within each.
+func Merge(dstIndexFile, src1, src2 string) error {
+	t1, err := Open(src1)
+	if err != nil {
+		return err
+	}
+	t2, err := Open(src2)
+	if err != nil {
+		return err
+	}
+	merged := NewTable()
+	for _, t := range []*Table{t1, t2} {
+		for name, entries := range t.byName {
+			for _, e := range entries {
+				merged.Add(name, Spot{File: e.File, Line: e.Line, Kind: e.Kind, Recv: e.Recv, Snippet: t.snippets[e.SnippetID]})
+			}
+		}
+	}
+	return Save(dstIndexFile, merged)
+}
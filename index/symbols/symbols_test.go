@@ -0,0 +1,185 @@
+// Copyright 2020 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package symbols
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+const goSrc = `package p
+
+type T struct{}
+
+func (t *T) Method() {}
+
+func Func() {}
+
+var X, Y = 1, 2
+
+const Z = 3
+`
+
+func TestGoExtractor(t *testing.T) {
+	spots, err := (GoExtractor{}).Extract("p.go", []byte(goSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]Spot)
+	for _, sp := range spots {
+		byName[sp.Name] = sp
+	}
+
+	want := map[string]Kind{"T": Type, "Method": Method, "Func": Func, "X": Var, "Y": Var, "Z": Const}
+	for name, kind := range want {
+		sp, ok := byName[name]
+		if !ok {
+			t.Errorf("missing declaration for %q", name)
+			continue
+		}
+		if sp.Kind != kind {
+			t.Errorf("%s: kind = %v, want %v", name, sp.Kind, kind)
+		}
+	}
+	if byName["Method"].Recv != "T" {
+		t.Errorf("Method receiver = %q, want \"T\"", byName["Method"].Recv)
+	}
+}
+
+func TestRegexExtractor(t *testing.T) {
+	rules := []Rule{{Pattern: regexp.MustCompile(`^def (\w+)`), Kind: Func}}
+	e := RegexExtractor{Rules: rules}
+	spots, err := e.Extract("a.py", []byte("def foo(x):\n    pass\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spots) != 1 || spots[0].Name != "foo" || spots[0].Kind != Func {
+		t.Fatalf("got %+v, want one Func spot named foo", spots)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte("# comment\nfunc\t^def (\\w+)\n\nconst\t^([A-Z_]+) =\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+	if rules[0].Kind != Func || rules[1].Kind != Const {
+		t.Errorf("got kinds %v, %v; want Func, Const", rules[0].Kind, rules[1].Kind)
+	}
+}
+
+func TestParseRulesBadLine(t *testing.T) {
+	if _, err := ParseRules([]byte("not-a-valid-line")); err == nil {
+		t.Fatal("expected an error for a line with no tab-separated kind/regex")
+	}
+}
+
+func TestTableAddLookup(t *testing.T) {
+	tbl := NewTable()
+	tbl.Add("Foo", Spot{File: "a.go", Line: 1, Kind: Func, Snippet: "func Foo() {}"})
+	tbl.Add("Foo", Spot{File: "b.go", Line: 2, Kind: Func, Snippet: "func Foo() {}"})
+
+	spots := tbl.Lookup("Foo")
+	if len(spots) != 2 {
+		t.Fatalf("got %d spots, want 2", len(spots))
+	}
+	if spots[0].Snippet != spots[1].Snippet {
+		t.Error("identical snippets should dedup to the same string")
+	}
+	if len(tbl.Lookup("Bar")) != 0 {
+		t.Error("Lookup of an unknown name should return nothing")
+	}
+}
+
+func TestTableEncodeDecodeRoundTrip(t *testing.T) {
+	tbl := NewTable()
+	tbl.Add("Foo", Spot{File: "a.go", Line: 1, Kind: Func, Snippet: "func Foo() {}"})
+	tbl.Add("Bar", Spot{File: "a.go", Line: 5, Kind: Method, Recv: "T", Snippet: "func (T) Bar() {}"})
+
+	var buf bytes.Buffer
+	if err := tbl.encodeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeTable(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Lookup("Foo")) != 1 || len(got.Lookup("Bar")) != 1 {
+		t.Fatalf("round-tripped table missing entries: %+v", got)
+	}
+	if got.Lookup("Bar")[0].Recv != "T" {
+		t.Errorf("round-tripped Recv = %q, want \"T\"", got.Lookup("Bar")[0].Recv)
+	}
+}
+
+func TestSaveOpenMerge(t *testing.T) {
+	dir := t.TempDir()
+	idx1 := dir + "/one"
+	idx2 := dir + "/two"
+
+	t1 := NewTable()
+	t1.Add("Foo", Spot{File: "a.go", Line: 1, Kind: Func, Snippet: "func Foo() {}"})
+	if err := Save(idx1, t1); err != nil {
+		t.Fatal(err)
+	}
+
+	t2 := NewTable()
+	t2.Add("Bar", Spot{File: "b.go", Line: 1, Kind: Func, Snippet: "func Bar() {}"})
+	if err := Save(idx2, t2); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := dir + "/merged"
+	if err := Merge(dst, idx1, idx2); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Lookup("Foo")) != 1 || len(merged.Lookup("Bar")) != 1 {
+		t.Fatalf("merged table missing entries from one of its inputs")
+	}
+}
+
+func TestRemoveFiles(t *testing.T) {
+	dir := t.TempDir()
+	idx := dir + "/idx"
+
+	tbl := NewTable()
+	tbl.Add("Foo", Spot{File: "a.go", Line: 1, Kind: Func, Snippet: "func Foo() {}"})
+	tbl.Add("Bar", Spot{File: "b.go", Line: 1, Kind: Func, Snippet: "func Bar() {}"})
+	if err := Save(idx, tbl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RemoveFiles(idx, []string{"a.go"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Open(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Lookup("Foo")) != 0 {
+		t.Error("RemoveFiles left a.go's declaration behind")
+	}
+	if len(got.Lookup("Bar")) != 1 {
+		t.Error("RemoveFiles dropped an entry it shouldn't have")
+	}
+}
+
+func TestOpenMissingSidecar(t *testing.T) {
+	tbl, err := Open("/nonexistent/path/for/sure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl.Lookup("anything")) != 0 {
+		t.Error("Open of a missing sidecar should return an empty table")
+	}
+}